@@ -1,19 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"math"
+	"log"
 	"net/http"
 	"regexp"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
 type Item struct {
@@ -21,19 +21,32 @@ type Item struct {
 	Price            string `json:"price" validate:"required"`
 }
 
+// PriceDecimal parses Price as an exact decimal, avoiding the rounding
+// errors float64 introduces for values like 0.10 or 0.20.
+func (i Item) PriceDecimal() (decimal.Decimal, error) {
+	return decimal.NewFromString(i.Price)
+}
+
 type Receipt struct {
-	Retailer     string `json:"retailer" validate:"required`
+	Retailer     string `json:"retailer" validate:"required"`
 	PurchaseDate string `json:"purchaseDate" validate:"required"`
 	PurchaseTime string `json:"purchaseTime" validate:"required"`
 	Items        []Item `json:"items" validate:"required"`
 	Total        string `json:"total" validate:"required"`
 }
 
+// TotalDecimal parses Total as an exact decimal, avoiding the rounding
+// errors float64 introduces for values like 0.10 or 0.20.
+func (r Receipt) TotalDecimal() (decimal.Decimal, error) {
+	return decimal.NewFromString(r.Total)
+}
+
 var (
-	receipts map[uuid.UUID]Receipt = make(map[uuid.UUID]Receipt)
-	points map[uuid.UUID]int64 = make(map[uuid.UUID]int64)
-	muReceipts sync.Mutex
-	muPoints sync.Mutex
+	store        ReceiptStore
+	scoringQueue JobQueue
+
+	rulesConfig RulesConfig
+	ruleEngine  *RuleEngine
 
 	retailer_re = regexp.MustCompile("^[\\w\\s\\-&]+$")
 	price_re = regexp.MustCompile("^\\d+\\.\\d{2}$")
@@ -80,7 +93,7 @@ func ReceiptValidation(sl validator.StructLevel) {
 		return
 	}
 
-	total, err := strconv.ParseFloat(receipt.Total, 64)
+	total, err := receipt.TotalDecimal()
 
 	if err != nil {
 		sl.ReportError(receipt.Total, "Total", "total", "totalnumber", "")
@@ -92,7 +105,7 @@ func ReceiptValidation(sl validator.StructLevel) {
 		return
 	}
 
-	var price_sum float64 = 0
+	price_sum := decimal.Zero
 
 	for index, item := range receipt.Items {
 		if !(price_re.MatchString(item.Price)) {
@@ -113,7 +126,7 @@ func ReceiptValidation(sl validator.StructLevel) {
 			return
 		}
 
-		item_price, err := strconv.ParseFloat(item.Price, 64)
+		item_price, err := item.PriceDecimal()
 		if err != nil {
 			sl.ReportError(item.Price,
 				fmt.Sprintf("Items[%d].Price", index),
@@ -122,12 +135,10 @@ func ReceiptValidation(sl validator.StructLevel) {
 				"")
 			return
 		}
-		price_sum += item_price
+		price_sum = price_sum.Add(item_price)
 	}
 
-	difference := price_sum - total
-
-	if (difference <= -0.01) || (difference >= 0.01) {
+	if !price_sum.Equal(total) {
 		sl.ReportError(receipt.Total,
 			"Total",
 			"total",
@@ -137,7 +148,44 @@ func ReceiptValidation(sl validator.StructLevel) {
 }
 
 func main() {
+	storeKindFlag := flag.String("store", "memory", "receipt store backend: memory, bolt, or redis")
+	storeDSNFlag := flag.String("store-dsn", "", "store backend connection string (bolt file path or redis address)")
+	rulesConfigFlag := flag.String("rules-config", "", "path to a YAML or JSON points ruleset (defaults to the built-in ruleset)")
+	queueKindFlag := flag.String("queue", "channel", "scoring job queue backend: channel, kafka, nats, or redis-streams")
+	queueDSNFlag := flag.String("queue-dsn", "", "queue backend connection string (ignored for the channel backend)")
+	workersFlag := flag.Int("workers", 4, "number of scoring worker goroutines")
+	flag.Parse()
+
+	var err error
+	store, err = NewReceiptStore(storeKind(*storeKindFlag), *storeDSNFlag)
+	if err != nil {
+		log.Fatalf("init receipt store: %v", err)
+	}
+
+	rulesConfig, err = LoadRulesConfig(*rulesConfigFlag)
+	if err != nil {
+		log.Fatalf("load rules config: %v", err)
+	}
+	ruleEngine = NewRuleEngine(rulesConfig)
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("init tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	scoringQueue, err = NewJobQueue(queueKind(*queueKindFlag), *queueDSNFlag)
+	if err != nil {
+		log.Fatalf("init job queue: %v", err)
+	}
+	defer scoringQueue.Close()
+
+	if err := startScoringWorkers(context.Background(), *workersFlag, scoringQueue); err != nil {
+		log.Fatalf("start scoring workers: %v", err)
+	}
+
 	router := gin.Default()
+	router.Use(ValidationErrorMiddleware())
 
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterStructValidation(ReceiptValidation, Receipt{})
@@ -145,118 +193,132 @@ func main() {
 
 	router.POST("/receipts/process", postReceipt)
 	router.GET("/receipts/:id/points", getPoints)
+	router.GET("/receipts/:id/status", getReceiptStatus)
+	router.GET("/rules", getRules)
+	router.GET("/metrics", metricsHandler())
 	router.Run(":8080")
 }
 
+func getRules(c *gin.Context) {
+	c.JSON(http.StatusOK, rulesConfig)
+}
+
 func postReceipt(c *gin.Context) {
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues("postReceipt").Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, span := tracer.Start(c.Request.Context(), "postReceipt")
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
 	var newReceipt Receipt
-	if err := c.BindJSON(&newReceipt); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+	if err := c.ShouldBindJSON(&newReceipt); err != nil {
+		c.Error(err)
+		c.Abort()
 		return
 	}
 	var id uuid.UUID = uuid.New()
-	muReceipts.Lock()
-	receipts[id] = newReceipt
-	muReceipts.Unlock()
+	if err := store.Save(id, newReceipt); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err := store.SaveStatus(id, JobStatusQueued); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err := scoringQueue.Enqueue(ScoringJob{ReceiptID: id}); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
+	receiptsProcessedTotal.Inc()
 	c.JSON(http.StatusOK, gin.H{"id": id.String()})
 }
 
-func calculatePoints(r Receipt) int64 {
-	var total int64 = 0
-	{ // One point for every alphanumeric character in the retailer name.
-		matches := alphanum_re.FindAllString(r.Retailer, -1)
-		total += int64(len(matches))
+// calculatePoints scores a receipt using the active RuleEngine, built
+// from rulesConfig at startup (see NewRuleEngine).
+func calculatePoints(ctx context.Context, r Receipt) int64 {
+	_, span := tracer.Start(ctx, "calculatePoints")
+	defer span.End()
+
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues("calculatePoints").Observe(time.Since(start).Seconds())
+	}()
+
+	return ruleEngine.Calculate(r)
+}
+
+// getPoints returns a receipt's score once its scoring job has finished.
+// While the job is still queued or processing it responds 202 with the
+// current status instead of blocking; see getReceiptStatus for polling
+// the same information without the points payload.
+func getPoints(c *gin.Context) {
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues("getPoints").Observe(time.Since(start).Seconds())
+	}()
+
+	_, span := tracer.Start(c.Request.Context(), "getPoints")
+	defer span.End()
+
+	id := c.Param("id")
+	uuidParsed, err := uuid.Parse(id)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
-	{ // 50 points if the total is a round dollar amount with no cents.
-		// 25 points if the total is a multiple of 0.25.
-		s := r.Total
-		var cents string = s[len(s)-2:]
-		if cents == "00" {
-			total += 50
-		}
-		multiples := map[string]struct{}{
-			"50": {},
-			"25": {},
-			"75": {},
-			"00": {},
-		}
-		if _, exists := multiples[cents]; exists {
-			total += 25
-		}
+
+	status, exists, err := store.GetStatus(uuidParsed)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
-	{ // 5 points for every two items on the receipt.
-		total += int64((len(r.Items) >> 1) * 5)
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
-	{ // If the trimmed length of the item description is a multiple of 3,
-		// multiply the price by 0.2 and round up to the nearest integer.
-		// The result is the number of points earned.
-		for _, item := range r.Items {
-			item_trimmed_desc := strings.TrimSpace(item.ShortDescription)
-			if len(item_trimmed_desc)%3 == 0 {
-				// Assuming no errors parsing this because this passed validation.
-				item_price, _ := strconv.ParseFloat(item.Price, 64)
-				item_price *= 0.2
-				total += int64(math.Ceil(item_price))
-			}
-		}
+
+	if status != JobStatusDone {
+		c.JSON(http.StatusAccepted, gin.H{"status": status})
+		return
 	}
-	{ //  6 points if the day in the purchase date is odd
-		odds := map[byte]struct{}{
-			'1': {},
-			'3': {},
-			'5': {},
-			'7': {},
-			'9': {},
-		}
-		s := r.PurchaseDate
-		dateLastDigit := s[len(s)-1]
-		if _, exists := odds[dateLastDigit]; exists {
-			total += 6
-		}
+
+	entry, exists, err := store.GetPoints(uuidParsed)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
-	{ // 10 points if the time of purchase is after 2:00pm and before 4:00pm.
-		s := r.PurchaseTime
-		if strings.Compare("14:00", s) == -1 && strings.Compare(s, "16:00") == -1 {
-			total += 10
-		}
+	if !exists {
+		pointsCacheTotal.WithLabelValues("miss").Inc()
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
-	return total
-
+	pointsCacheTotal.WithLabelValues("hit").Inc()
+	c.JSON(http.StatusOK, gin.H{"points": entry})
 }
 
-func getPoints(c *gin.Context) {
+// getReceiptStatus surfaces the lifecycle of a receipt's scoring job:
+// queued, processing, done, or failed.
+func getReceiptStatus(c *gin.Context) {
 	id := c.Param("id")
 	uuidParsed, err := uuid.Parse(id)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
-	var exists bool
-	var entry int64
-	muPoints.Lock()
-	entry, exists = points[uuidParsed]
-	muPoints.Unlock()
-
-	if exists {
-		c.JSON(http.StatusOK, gin.H{"points": entry})
+	status, exists, err := store.GetStatus(uuidParsed)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-
-	var receipt Receipt
-	muReceipts.Lock()
-	receipt, exists = receipts[uuidParsed]
-	muReceipts.Unlock()
-
 	if !exists {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	pointCount := calculatePoints(receipt)
-	muPoints.Lock()
-	points[uuidParsed] = pointCount
-	muPoints.Unlock()
-	c.JSON(http.StatusOK, gin.H{"points": pointCount})
-
+	c.JSON(http.StatusOK, gin.H{"status": status})
 }
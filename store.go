@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// ReceiptStore is the persistence boundary for receipts and their computed
+// points. Implementations must be safe for concurrent use.
+type ReceiptStore interface {
+	Save(id uuid.UUID, r Receipt) error
+	Get(id uuid.UUID) (Receipt, bool, error)
+	SavePoints(id uuid.UUID, points int64) error
+	GetPoints(id uuid.UUID) (int64, bool, error)
+	SaveStatus(id uuid.UUID, status JobStatus) error
+	GetStatus(id uuid.UUID) (JobStatus, bool, error)
+
+	// ClaimProcessing atomically transitions a receipt's status to
+	// JobStatusProcessing and reports whether the caller won the claim.
+	// The claim succeeds if the receipt has no status yet, or its status
+	// is JobStatusQueued or JobStatusFailed, or it is JobStatusProcessing
+	// with a lease older than staleAfter (a worker that crashed mid-job).
+	// It fails if another worker holds a live lease or the job is already
+	// JobStatusDone, which is what makes redelivery safe to retry without
+	// double-scoring.
+	ClaimProcessing(id uuid.UUID, staleAfter time.Duration) (bool, error)
+}
+
+// storeKind selects which ReceiptStore implementation NewReceiptStore builds.
+type storeKind string
+
+const (
+	storeKindMemory storeKind = "memory"
+	storeKindBolt   storeKind = "bolt"
+	storeKindRedis  storeKind = "redis"
+)
+
+var (
+	receiptsBucket = []byte("receipts")
+	pointsBucket   = []byte("points")
+	statusBucket   = []byte("status")
+)
+
+// statusRecord is the persisted shape of a receipt's job status, including
+// the lease timestamp ClaimProcessing needs to detect a crashed worker.
+type statusRecord struct {
+	Status    JobStatus `json:"status"`
+	ClaimedAt time.Time `json:"claimedAt,omitempty"`
+}
+
+// canClaim reports whether a worker may move rec into JobStatusProcessing,
+// given no current record (exists == false) or the one read from storage.
+func canClaim(rec statusRecord, exists bool, staleAfter time.Duration) bool {
+	if !exists {
+		return true
+	}
+	switch rec.Status {
+	case JobStatusQueued, JobStatusFailed:
+		return true
+	case JobStatusProcessing:
+		return time.Since(rec.ClaimedAt) >= staleAfter
+	default: // JobStatusDone
+		return false
+	}
+}
+
+// NewReceiptStore builds the ReceiptStore selected by kind. dsn is the
+// bolt file path for storeKindBolt, or the redis connection address for
+// storeKindRedis; it is ignored for storeKindMemory.
+func NewReceiptStore(kind storeKind, dsn string) (ReceiptStore, error) {
+	switch kind {
+	case storeKindMemory, "":
+		return newMemoryStore(), nil
+	case storeKindBolt:
+		return newBoltStore(dsn)
+	case storeKindRedis:
+		return newRedisStore(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown store kind %q", kind)
+	}
+}
+
+// memoryStore is the original in-process map-backed store, kept as the
+// zero-config default and for tests.
+type memoryStore struct {
+	muReceipts sync.Mutex
+	muPoints   sync.Mutex
+	muStatus   sync.Mutex
+	receipts   map[uuid.UUID]Receipt
+	points     map[uuid.UUID]int64
+	status     map[uuid.UUID]statusRecord
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		receipts: make(map[uuid.UUID]Receipt),
+		points:   make(map[uuid.UUID]int64),
+		status:   make(map[uuid.UUID]statusRecord),
+	}
+}
+
+func (s *memoryStore) Save(id uuid.UUID, r Receipt) error {
+	s.muReceipts.Lock()
+	defer s.muReceipts.Unlock()
+	s.receipts[id] = r
+	return nil
+}
+
+func (s *memoryStore) Get(id uuid.UUID) (Receipt, bool, error) {
+	s.muReceipts.Lock()
+	defer s.muReceipts.Unlock()
+	r, ok := s.receipts[id]
+	return r, ok, nil
+}
+
+func (s *memoryStore) SavePoints(id uuid.UUID, points int64) error {
+	s.muPoints.Lock()
+	defer s.muPoints.Unlock()
+	s.points[id] = points
+	return nil
+}
+
+func (s *memoryStore) GetPoints(id uuid.UUID) (int64, bool, error) {
+	s.muPoints.Lock()
+	defer s.muPoints.Unlock()
+	p, ok := s.points[id]
+	return p, ok, nil
+}
+
+func (s *memoryStore) SaveStatus(id uuid.UUID, status JobStatus) error {
+	s.muStatus.Lock()
+	defer s.muStatus.Unlock()
+	s.status[id] = statusRecord{Status: status}
+	return nil
+}
+
+func (s *memoryStore) GetStatus(id uuid.UUID) (JobStatus, bool, error) {
+	s.muStatus.Lock()
+	defer s.muStatus.Unlock()
+	rec, ok := s.status[id]
+	return rec.Status, ok, nil
+}
+
+func (s *memoryStore) ClaimProcessing(id uuid.UUID, staleAfter time.Duration) (bool, error) {
+	s.muStatus.Lock()
+	defer s.muStatus.Unlock()
+	rec, exists := s.status[id]
+	if !canClaim(rec, exists, staleAfter) {
+		return false, nil
+	}
+	s.status[id] = statusRecord{Status: JobStatusProcessing, ClaimedAt: time.Now()}
+	return true, nil
+}
+
+// boltStore persists receipts and points to a local BoltDB file, so data
+// survives process restarts on a single node.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(receiptsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(pointsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(statusBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init bolt buckets: %w", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Save(id uuid.UUID, r Receipt) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(receiptsBucket).Put(id[:], data)
+	})
+}
+
+func (s *boltStore) Get(id uuid.UUID) (Receipt, bool, error) {
+	var r Receipt
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(receiptsBucket).Get(id[:])
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &r)
+	})
+	return r, found, err
+}
+
+func (s *boltStore) SavePoints(id uuid.UUID, points int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pointsBucket).Put(id[:], []byte(fmt.Sprintf("%d", points)))
+	})
+}
+
+func (s *boltStore) GetPoints(id uuid.UUID) (int64, bool, error) {
+	var points int64
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(pointsBucket).Get(id[:])
+		if data == nil {
+			return nil
+		}
+		found = true
+		_, err := fmt.Sscanf(string(data), "%d", &points)
+		return err
+	})
+	return points, found, err
+}
+
+func (s *boltStore) SaveStatus(id uuid.UUID, status JobStatus) error {
+	data, err := json.Marshal(statusRecord{Status: status})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statusBucket).Put(id[:], data)
+	})
+}
+
+func (s *boltStore) GetStatus(id uuid.UUID) (JobStatus, bool, error) {
+	rec, found, err := s.getStatusRecord(id)
+	return rec.Status, found, err
+}
+
+func (s *boltStore) getStatusRecord(id uuid.UUID) (statusRecord, bool, error) {
+	var rec statusRecord
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(statusBucket).Get(id[:])
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, found, err
+}
+
+// ClaimProcessing runs the read-check-write inside a single Bolt write
+// transaction, which Bolt serializes, so the claim is atomic even with
+// concurrent redelivery of the same receipt.
+func (s *boltStore) ClaimProcessing(id uuid.UUID, staleAfter time.Duration) (bool, error) {
+	var claimed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(statusBucket)
+		var rec statusRecord
+		exists := false
+		if data := bucket.Get(id[:]); data != nil {
+			exists = true
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+		}
+		if !canClaim(rec, exists, staleAfter) {
+			return nil
+		}
+		data, err := json.Marshal(statusRecord{Status: JobStatusProcessing, ClaimedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(id[:], data); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	return claimed, err
+}
+
+// redisStore persists receipts and points in Redis, so multiple API
+// instances behind a load balancer share the same state.
+type redisStore struct {
+	client *redis.Client
+}
+
+func newRedisStore(addr string) *redisStore {
+	return &redisStore{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (s *redisStore) Save(id uuid.UUID, r Receipt) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), receiptKey(id), data, 0).Err()
+}
+
+func (s *redisStore) Get(id uuid.UUID) (Receipt, bool, error) {
+	var r Receipt
+	data, err := s.client.Get(context.Background(), receiptKey(id)).Bytes()
+	if err == redis.Nil {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+func (s *redisStore) SavePoints(id uuid.UUID, points int64) error {
+	return s.client.Set(context.Background(), pointsKey(id), points, 0).Err()
+}
+
+func (s *redisStore) GetPoints(id uuid.UUID) (int64, bool, error) {
+	points, err := s.client.Get(context.Background(), pointsKey(id)).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return points, true, nil
+}
+
+// redisStatusRecord is the JSON shape stored under a status key. ClaimedAt
+// is a unix timestamp rather than RFC3339 so the claimProcessingScript can
+// compare it numerically without a date parser.
+type redisStatusRecord struct {
+	Status    JobStatus `json:"status"`
+	ClaimedAt int64     `json:"claimedAt,omitempty"`
+}
+
+func (s *redisStore) SaveStatus(id uuid.UUID, status JobStatus) error {
+	data, err := json.Marshal(redisStatusRecord{Status: status})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), statusKey(id), data, 0).Err()
+}
+
+func (s *redisStore) GetStatus(id uuid.UUID) (JobStatus, bool, error) {
+	data, err := s.client.Get(context.Background(), statusKey(id)).Bytes()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	var rec redisStatusRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return "", false, err
+	}
+	return rec.Status, true, nil
+}
+
+// claimProcessingScript performs the same canClaim check as the other
+// backends, but atomically inside Redis via EVAL so two redeliveries
+// racing on the same receipt can't both win the claim.
+var claimProcessingScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+local canClaim = false
+if not data then
+	canClaim = true
+else
+	local rec = cjson.decode(data)
+	if rec.status == 'queued' or rec.status == 'failed' then
+		canClaim = true
+	elseif rec.status == 'processing' then
+		if (tonumber(ARGV[2]) - (rec.claimedAt or 0)) >= tonumber(ARGV[3]) then
+			canClaim = true
+		end
+	end
+end
+if canClaim then
+	redis.call('SET', KEYS[1], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func (s *redisStore) ClaimProcessing(id uuid.UUID, staleAfter time.Duration) (bool, error) {
+	now := time.Now().Unix()
+	record, err := json.Marshal(redisStatusRecord{Status: JobStatusProcessing, ClaimedAt: now})
+	if err != nil {
+		return false, err
+	}
+	result, err := claimProcessingScript.Run(context.Background(), s.client,
+		[]string{statusKey(id)}, string(record), now, int64(staleAfter.Seconds())).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+func receiptKey(id uuid.UUID) string { return "receipt:" + id.String() }
+func pointsKey(id uuid.UUID) string  { return "points:" + id.String() }
+func statusKey(id uuid.UUID) string  { return "status:" + id.String() }